@@ -0,0 +1,398 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nuagenetworks/libvrsdk/api/entity"
+	"github.com/nuagenetworks/libvrsdk/ovsdb"
+	"github.com/socketplane/libovsdb"
+)
+
+// nuageServiceDatabase is the OVSDB database that Nuage_VM_Table lives in.
+const nuageServiceDatabase = "Nuage_Service"
+
+// BatchOptions configures automatic flushing of a Txn, for high-throughput
+// agents that would rather not track operation counts or call Commit
+// themselves.
+type BatchOptions struct {
+	// MaxOps commits the Txn once it has accumulated this many operations.
+	// Zero disables the limit.
+	MaxOps int
+	// FlushInterval commits the Txn once, this long after AutoFlush is
+	// called. Zero disables the timer. The timer is not re-armed, and any
+	// earlier Commit, including one triggered by MaxOps, cancels it.
+	FlushInterval time.Duration
+}
+
+// txnOp pairs the OVSDB operations a queued mutation expands into with a
+// description, so that a failed operation can be reported back against the
+// entity that caused it, and an onSuccess hook that applies the same
+// in-memory cache updates CreateEntity/DestroyEntity/AddEntityPort/
+// RemoveEntityPort make on the non-batched path, run only once Commit has
+// confirmed the operation actually landed.
+type txnOp struct {
+	description string
+	ovsOps      []libovsdb.Operation
+	onSuccess   func()
+}
+
+// Txn accumulates entity mutations and commits them to the VRS as a single
+// OVSDB transaction, cutting down on round-trips during bulk pod churn.
+// A Txn is not safe for concurrent use by several callers; its mutex only
+// guards the queue against the commits AutoFlush makes from its own
+// goroutine.
+type Txn struct {
+	vrsConnection *VRSConnection
+	options       BatchOptions
+
+	mutex        sync.Mutex
+	ops          []txnOp
+	timer        *time.Timer
+	pendingPorts map[string][]string
+}
+
+// Batch returns a Txn that accumulates CreateEntity, DestroyEntity,
+// AddEntityPort, RemoveEntityPort, SetEntityState, PostEntityEvent and
+// SetEntityMetadata mutations for a single Commit.
+func (vrsConnection *VRSConnection) Batch() *Txn {
+	return &Txn{vrsConnection: vrsConnection}
+}
+
+// AutoFlush enables automatic commits once the Txn reaches options.MaxOps
+// queued operations or options.FlushInterval elapses, whichever comes
+// first. It is intended for high-throughput agents that queue mutations as
+// they happen rather than batching them up front. The errors returned by
+// these automatic commits are discarded.
+func (txn *Txn) AutoFlush(options BatchOptions) {
+	txn.mutex.Lock()
+	defer txn.mutex.Unlock()
+
+	txn.options = options
+	if txn.timer != nil {
+		txn.timer.Stop()
+	}
+	if options.FlushInterval > 0 {
+		txn.timer = time.AfterFunc(options.FlushInterval, func() {
+			txn.Commit()
+		})
+	}
+}
+
+func (txn *Txn) enqueue(description string, ovsOps []libovsdb.Operation, onSuccess func()) {
+	txn.mutex.Lock()
+	txn.ops = append(txn.ops, txnOp{description: description, ovsOps: ovsOps, onSuccess: onSuccess})
+	shouldFlush := txn.options.MaxOps > 0 && len(txn.ops) >= txn.options.MaxOps
+	txn.mutex.Unlock()
+
+	if shouldFlush {
+		txn.Commit()
+	}
+}
+
+// CreateEntity queues an entity creation for the next Commit.
+func (txn *Txn) CreateEntity(info EntityInfo) error {
+	if len(info.UUID) == 0 {
+		return fmt.Errorf("Uuid absent")
+	}
+
+	if len(info.Name) == 0 {
+		return fmt.Errorf("Name absent")
+	}
+
+	var metadata map[string]string
+	if info.Metadata != nil {
+		metadata = make(map[string]string)
+		for k, v := range info.Metadata {
+			metadata[string(k)] = v
+		}
+	}
+	delete(metadata, string(entity.MetadataKeyUser))
+	for _, tag := range info.Tags {
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		metadata[tagMetadataKeyPrefix+tag.Name] = tag.ExternalID
+	}
+
+	nuageVMTableRow := ovsdb.NuageVMTableRow{
+		Type:            int(info.Type),
+		Event:           int(entity.EventCategoryDefined),
+		EventType:       int(entity.EventDefinedAdded),
+		State:           int(entity.Running),
+		Reason:          int(entity.RunningUnknown),
+		VMName:          info.Name,
+		VMUuid:          info.UUID,
+		Domain:          info.Domain,
+		NuageUser:       info.Metadata[entity.MetadataKeyUser],
+		NuageEnterprise: info.Metadata[entity.MetadataKeyEnterprise],
+		Metadata:        metadata,
+		Ports:           info.Ports,
+	}
+
+	ovsOps, err := txn.vrsConnection.vmTable.InsertRowOp(&nuageVMTableRow)
+	if err != nil {
+		return fmt.Errorf("Problem adding entity info to VRS %v", err)
+	}
+
+	txn.setPendingPorts(info.UUID, append([]string{}, info.Ports...))
+
+	txn.enqueue(fmt.Sprintf("CreateEntity(%s)", info.UUID), ovsOps, func() {
+		txn.vrsConnection.cacheEntity(info)
+	})
+
+	return nil
+}
+
+// DestroyEntity queues the removal of the entity identified by uuid for the
+// next Commit.
+func (txn *Txn) DestroyEntity(uuid string) error {
+	condition := []string{ovsdb.NuageVMTableColumnVMUUID, "==", uuid}
+
+	ovsOps, err := txn.vrsConnection.vmTable.DeleteRowOp(condition)
+	if err != nil {
+		return fmt.Errorf("Unable to delete the entity from VRS %v", err)
+	}
+
+	txn.enqueue(fmt.Sprintf("DestroyEntity(%s)", uuid), ovsOps, func() {
+		txn.vrsConnection.uncacheEntity(uuid)
+	})
+
+	return nil
+}
+
+// AddEntityPort queues adding portName to the entity identified by uuid for
+// the next Commit. Ports are tracked per uuid within the Txn rather than
+// read from the live VRS on every call, so queuing several port mutations
+// (or a CreateEntity followed by AddEntityPort) for the same uuid in one
+// batch accumulates correctly instead of each call clobbering the last.
+func (txn *Txn) AddEntityPort(uuid string, portName string) error {
+	ports, err := txn.portsFor(uuid)
+	if err != nil {
+		return fmt.Errorf("Unable to get existing ports %s %s", uuid, err)
+	}
+
+	return txn.setEntityPorts(uuid, append(append([]string{}, ports...), portName), fmt.Sprintf("AddEntityPort(%s, %s)", uuid, portName))
+}
+
+// RemoveEntityPort queues removing portName from the entity identified by
+// uuid for the next Commit. See AddEntityPort for how ports are tracked
+// within the Txn.
+func (txn *Txn) RemoveEntityPort(uuid string, portName string) error {
+	ports, err := txn.portsFor(uuid)
+	if err != nil {
+		return fmt.Errorf("Unable to get existing ports %s %s", uuid, err)
+	}
+
+	portIndex := -1
+	for i, port := range ports {
+		if strings.Compare(port, portName) == 0 {
+			portIndex = i
+			break
+		}
+	}
+
+	if portIndex == -1 {
+		return fmt.Errorf("%s port %s not found", uuid, portName)
+	}
+
+	ports = append(append([]string{}, ports[:portIndex]...), ports[(portIndex+1):]...)
+
+	return txn.setEntityPorts(uuid, ports, fmt.Sprintf("RemoveEntityPort(%s, %s)", uuid, portName))
+}
+
+// portsFor returns the port list that the next queued port mutation for
+// uuid should build on. The first touch for a given uuid within this Txn
+// seeds it from GetEntityPorts (or, if CreateEntity already queued a row
+// for uuid in this same Txn, from the ports that row will be created
+// with); every later touch for that uuid reads back the in-memory result
+// of the previous queued mutation instead of live VRS state, which may not
+// reflect anything queued-but-not-yet-committed.
+func (txn *Txn) portsFor(uuid string) ([]string, error) {
+	txn.mutex.Lock()
+	if ports, found := txn.pendingPorts[uuid]; found {
+		txn.mutex.Unlock()
+		return ports, nil
+	}
+	txn.mutex.Unlock()
+
+	ports, err := txn.vrsConnection.GetEntityPorts(uuid)
+	if err != nil {
+		return nil, err
+	}
+
+	txn.setPendingPorts(uuid, ports)
+	return ports, nil
+}
+
+// setPendingPorts records ports as the current in-Txn port list for uuid,
+// so the next portsFor call for the same uuid sees it instead of re-reading
+// live VRS state.
+func (txn *Txn) setPendingPorts(uuid string, ports []string) {
+	txn.mutex.Lock()
+	defer txn.mutex.Unlock()
+	if txn.pendingPorts == nil {
+		txn.pendingPorts = make(map[string][]string)
+	}
+	txn.pendingPorts[uuid] = ports
+}
+
+func (txn *Txn) setEntityPorts(uuid string, ports []string, description string) error {
+	row := make(map[string]interface{})
+	ovsSet, err := libovsdb.NewOvsSet(ports)
+	if err != nil {
+		return err
+	}
+	row[ovsdb.NuageVMTableColumnPorts] = ovsSet
+
+	condition := []string{ovsdb.NuageVMTableColumnVMUUID, "==", uuid}
+
+	ovsOps, err := txn.vrsConnection.vmTable.UpdateRowOp(row, condition)
+	if err != nil {
+		return fmt.Errorf("Unable to update ports %s %v", uuid, err)
+	}
+
+	txn.setPendingPorts(uuid, ports)
+
+	txn.enqueue(description, ovsOps, func() {
+		txn.vrsConnection.cacheEntityPorts(uuid, ports)
+	})
+
+	return nil
+}
+
+// SetEntityState queues an entity state update for the next Commit.
+func (txn *Txn) SetEntityState(uuid string, state entity.State, subState entity.SubState) error {
+	row := make(map[string]interface{})
+	row[ovsdb.NuageVMTableColumnState] = int(state)
+	row[ovsdb.NuageVMTableColumnReason] = int(subState)
+
+	condition := []string{ovsdb.NuageVMTableColumnVMUUID, "==", uuid}
+
+	ovsOps, err := txn.vrsConnection.vmTable.UpdateRowOp(row, condition)
+	if err != nil {
+		return fmt.Errorf("Unable to update the state %s %v %v %v", uuid, state, subState, err)
+	}
+
+	txn.enqueue(fmt.Sprintf("SetEntityState(%s)", uuid), ovsOps, nil)
+
+	return nil
+}
+
+// PostEntityEvent queues a new entity event for the next Commit.
+func (txn *Txn) PostEntityEvent(uuid string, evtCategory entity.EventCategory, evt entity.Event) error {
+	if !entity.ValidateEvent(evtCategory, evt) {
+		return fmt.Errorf("Invalid event %v for event category %v", evt, evtCategory)
+	}
+
+	row := make(map[string]interface{})
+	row[ovsdb.NuageVMTableColumnEventCategory] = int(evtCategory)
+	row[ovsdb.NuageVMTableColumnEventType] = int(evt)
+
+	condition := []string{ovsdb.NuageVMTableColumnVMUUID, "==", uuid}
+
+	ovsOps, err := txn.vrsConnection.vmTable.UpdateRowOp(row, condition)
+	if err != nil {
+		return fmt.Errorf("Unable to send the state %s %v %v %v", uuid, evtCategory, evt, err)
+	}
+
+	txn.enqueue(fmt.Sprintf("PostEntityEvent(%s)", uuid), ovsOps, nil)
+
+	return nil
+}
+
+// SetEntityMetadata queues a Metadata update for the next Commit.
+func (txn *Txn) SetEntityMetadata(uuid string, metadata map[entity.MetadataKey]string) error {
+	row := make(map[string]interface{})
+	row[ovsdb.NuageVMTableColumnMetadata] = metadata
+
+	condition := []string{ovsdb.NuageVMTableColumnVMUUID, "==", uuid}
+
+	ovsOps, err := txn.vrsConnection.vmTable.UpdateRowOp(row, condition)
+	if err != nil {
+		return fmt.Errorf("Unable to update the metadata %s %v %v", uuid, metadata, err)
+	}
+
+	txn.enqueue(fmt.Sprintf("SetEntityMetadata(%s)", uuid), ovsOps, nil)
+
+	return nil
+}
+
+// Commit executes every queued mutation as a single OVSDB transaction and
+// returns one error per queued operation, in the order the operations were
+// queued, so that callers can identify which entity in the batch failed.
+// The OVSDB server aborts and rolls back the whole transaction as soon as
+// one operation in it fails, so a failure anywhere in the batch means none
+// of it was applied: every returned error is non-nil in that case, not just
+// the one for the operation that actually failed. A nil entry means the
+// whole batch committed and that operation's cache side effects (if any)
+// have already run. Apart from the commits AutoFlush makes on its own, a
+// Txn must not be reused after Commit.
+func (txn *Txn) Commit() []error {
+	txn.mutex.Lock()
+	if txn.timer != nil {
+		txn.timer.Stop()
+	}
+	queued := txn.ops
+	txn.ops = nil
+	txn.mutex.Unlock()
+
+	if len(queued) == 0 {
+		return nil
+	}
+
+	var allOps []libovsdb.Operation
+	for _, q := range queued {
+		allOps = append(allOps, q.ovsOps...)
+	}
+
+	results, err := txn.vrsConnection.client().Transact(nuageServiceDatabase, allOps...)
+	if err != nil {
+		return failAll(queued, fmt.Errorf("Transaction failed %v", err))
+	}
+
+	if failure := firstFailure(queued, results); failure != nil {
+		return failAll(queued, failure)
+	}
+
+	errs := make([]error, len(queued))
+	for _, q := range queued {
+		if q.onSuccess != nil {
+			q.onSuccess()
+		}
+	}
+	return errs
+}
+
+// firstFailure reports the first queued operation whose result came back
+// with an error, or whose result is missing because the server stopped
+// executing operations after an earlier one failed. It returns nil if
+// every operation in the transaction succeeded.
+func firstFailure(queued []txnOp, results []libovsdb.OperationResult) error {
+	resultIndex := 0
+	for _, q := range queued {
+		for range q.ovsOps {
+			if resultIndex >= len(results) {
+				return fmt.Errorf("%s was not applied: transaction aborted", q.description)
+			}
+			if results[resultIndex].Error != "" {
+				return fmt.Errorf("%s failed: %s %s", q.description, results[resultIndex].Error, results[resultIndex].Details)
+			}
+			resultIndex++
+		}
+	}
+	return nil
+}
+
+// failAll reports cause against every queued operation, since an OVSDB
+// transaction failure rolls back the entire batch rather than leaving the
+// operations before the failure applied.
+func failAll(queued []txnOp, cause error) []error {
+	errs := make([]error, len(queued))
+	for i, q := range queued {
+		errs[i] = fmt.Errorf("%s not committed: %v", q.description, cause)
+	}
+	return errs
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/nuagenetworks/libvrsdk/api/entity"
+	"github.com/nuagenetworks/libvrsdk/api/metadatatag"
+	"github.com/nuagenetworks/libvrsdk/ovsdb"
+	"github.com/socketplane/libovsdb"
+)
+
+func TestFirstFailureReportsTheFailingOp(t *testing.T) {
+	queued := []txnOp{
+		{description: "CreateEntity(a)", ovsOps: []libovsdb.Operation{{}}},
+		{description: "CreateEntity(b)", ovsOps: []libovsdb.Operation{{}}},
+		{description: "CreateEntity(c)", ovsOps: []libovsdb.Operation{{}}},
+	}
+	results := []libovsdb.OperationResult{
+		{},
+		{Error: "constraint violation", Details: "duplicate uuid"},
+		{},
+	}
+
+	err := firstFailure(queued, results)
+	if err == nil {
+		t.Fatalf("expected an error, got nil")
+	}
+	if !strings.Contains(err.Error(), "CreateEntity(b)") {
+		t.Errorf("expected the failure to name the failing op, got %q", err.Error())
+	}
+}
+
+func TestFirstFailureSucceedsWhenEveryResultIsClean(t *testing.T) {
+	queued := []txnOp{
+		{description: "CreateEntity(a)", ovsOps: []libovsdb.Operation{{}}},
+		{description: "CreateEntity(b)", ovsOps: []libovsdb.Operation{{}}},
+	}
+	results := []libovsdb.OperationResult{{}, {}}
+
+	if err := firstFailure(queued, results); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestFailAllMarksEveryQueuedOpAsFailed(t *testing.T) {
+	queued := []txnOp{
+		{description: "CreateEntity(a)"},
+		{description: "DestroyEntity(b)"},
+		{description: "SetEntityState(c)"},
+	}
+
+	errs := failAll(queued, errFixture)
+
+	if len(errs) != len(queued) {
+		t.Fatalf("expected %d errors, got %d", len(queued), len(errs))
+	}
+	for i, err := range errs {
+		if err == nil {
+			t.Errorf("op %d (%s): expected a non-nil error, since the whole batch rolled back, got nil", i, queued[i].description)
+		}
+	}
+}
+
+var errFixture = &testError{"boom"}
+
+type testError struct{ msg string }
+
+func (e *testError) Error() string { return e.msg }
+
+func TestTagsFromMetadataRoundTripsWithAttachMetadataTag(t *testing.T) {
+	metadata := map[entity.MetadataKey]string{
+		entity.MetadataKey(tagMetadataKeyPrefix + "blue"):  "svc-blue",
+		entity.MetadataKey(tagMetadataKeyPrefix + "green"): "svc-green",
+		"not-a-tag": "should be ignored",
+	}
+
+	tags := tagsFromMetadata(metadata)
+
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+
+	want := []metadatatag.MetadataTag{
+		{Name: "blue", ExternalID: "svc-blue"},
+		{Name: "green", ExternalID: "svc-green"},
+	}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("tagsFromMetadata(%v) = %v, want %v", metadata, tags, want)
+	}
+}
+
+func TestTagsFromMetadataSkipsKeysWithoutTagPrefix(t *testing.T) {
+	metadata := map[entity.MetadataKey]string{
+		entity.MetadataKeyUser: "alice",
+	}
+
+	if tags := tagsFromMetadata(metadata); tags != nil {
+		t.Errorf("expected no tags for metadata with no tag-prefixed keys, got %v", tags)
+	}
+}
+
+// fakeOpsTable is a minimal ovsdb.OvsdbTable that never touches a live VRS;
+// it exists so Txn tests can exercise real queuing logic without an OVSDB
+// connection. Every op-returning method hands back a single no-op
+// Operation, since the tests below only care about what Txn does with its
+// own in-memory state before Commit, not the wire format of the result.
+type fakeOpsTable struct{}
+
+func (fakeOpsTable) InsertRow(*libovsdb.OvsdbClient, *ovsdb.NuageVMTableRow) error { return nil }
+func (fakeOpsTable) DeleteRow(*libovsdb.OvsdbClient, []string) error               { return nil }
+func (fakeOpsTable) UpdateRow(*libovsdb.OvsdbClient, map[string]interface{}, []string) error {
+	return nil
+}
+func (fakeOpsTable) ReadRow(*libovsdb.OvsdbClient, ovsdb.ReadRowArgs) (map[string]interface{}, error) {
+	return nil, errFixture
+}
+func (fakeOpsTable) ReadRows(*libovsdb.OvsdbClient, ovsdb.ReadRowArgs) ([]map[string]interface{}, error) {
+	return nil, errFixture
+}
+func (fakeOpsTable) InsertRowOp(*ovsdb.NuageVMTableRow) ([]libovsdb.Operation, error) {
+	return []libovsdb.Operation{{}}, nil
+}
+func (fakeOpsTable) DeleteRowOp([]string) ([]libovsdb.Operation, error) {
+	return []libovsdb.Operation{{}}, nil
+}
+func (fakeOpsTable) UpdateRowOp(map[string]interface{}, []string) ([]libovsdb.Operation, error) {
+	return []libovsdb.Operation{{}}, nil
+}
+
+// TestTxnAddEntityPortAccumulatesWithinABatch covers the "bulk pod churn"
+// scenario AddEntityPort/RemoveEntityPort are built for: queuing a
+// CreateEntity and two AddEntityPort calls for the same uuid in one Txn
+// must build each port list on top of the previous queued mutation, not
+// re-read the (stale, pre-batch) live port list every time.
+func TestTxnAddEntityPortAccumulatesWithinABatch(t *testing.T) {
+	vrsConnection := &VRSConnection{
+		vmTable:       fakeOpsTable{},
+		knownEntities: make(map[string]EntityInfo),
+	}
+	txn := vrsConnection.Batch()
+
+	if err := txn.CreateEntity(EntityInfo{UUID: "vm-1", Name: "vm-1", Ports: []string{"eth0"}}); err != nil {
+		t.Fatalf("CreateEntity: %v", err)
+	}
+	if err := txn.AddEntityPort("vm-1", "eth1"); err != nil {
+		t.Fatalf("AddEntityPort(eth1): %v", err)
+	}
+	if err := txn.AddEntityPort("vm-1", "eth2"); err != nil {
+		t.Fatalf("AddEntityPort(eth2): %v", err)
+	}
+
+	want := []string{"eth0", "eth1", "eth2"}
+	if got := txn.pendingPorts["vm-1"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("pendingPorts[vm-1] = %v, want %v", got, want)
+	}
+
+	if len(txn.ops) != 3 {
+		t.Fatalf("expected 3 queued ops (CreateEntity + 2 AddEntityPort), got %d", len(txn.ops))
+	}
+}
+
+// TestTxnRemoveEntityPortSeesPriorQueuedAdd proves RemoveEntityPort, like
+// AddEntityPort, builds on whatever this Txn already queued for the uuid
+// rather than the live port list.
+func TestTxnRemoveEntityPortSeesPriorQueuedAdd(t *testing.T) {
+	vrsConnection := &VRSConnection{
+		vmTable:       fakeOpsTable{},
+		knownEntities: make(map[string]EntityInfo),
+	}
+	txn := vrsConnection.Batch()
+
+	if err := txn.CreateEntity(EntityInfo{UUID: "vm-1", Name: "vm-1"}); err != nil {
+		t.Fatalf("CreateEntity: %v", err)
+	}
+	if err := txn.AddEntityPort("vm-1", "eth0"); err != nil {
+		t.Fatalf("AddEntityPort(eth0): %v", err)
+	}
+	if err := txn.RemoveEntityPort("vm-1", "eth0"); err != nil {
+		t.Fatalf("RemoveEntityPort(eth0): %v", err)
+	}
+
+	want := []string{}
+	if got := txn.pendingPorts["vm-1"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("pendingPorts[vm-1] = %v, want %v", got, want)
+	}
+}
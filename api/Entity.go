@@ -3,6 +3,7 @@ package api
 import (
 	"fmt"
 	"github.com/nuagenetworks/libvrsdk/api/entity"
+	"github.com/nuagenetworks/libvrsdk/api/metadatatag"
 	"github.com/nuagenetworks/libvrsdk/ovsdb"
 	"github.com/socketplane/libovsdb"
 	"strings"
@@ -16,6 +17,7 @@ type EntityInfo struct {
 	Domain   entity.Domain
 	Ports    []string
 	Metadata map[entity.MetadataKey]string
+	Tags     []metadatatag.MetadataTag
 }
 
 // CreateEntity adds an entity to the Nuage VRS
@@ -41,6 +43,16 @@ func (vrsConnection *VRSConnection) CreateEntity(info EntityInfo) error {
 	//delete(metadata, string(entity.MetadataKeyEnterprise))
 	delete(metadata, string(entity.MetadataKeyUser))
 
+	// MetadataTags are not a VM table column of their own, so fold them
+	// into Metadata under a reserved key namespace. This keeps them
+	// visible to existing consumers that only understand Metadata.
+	for _, tag := range info.Tags {
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		metadata[tagMetadataKeyPrefix+tag.Name] = tag.ExternalID
+	}
+
 	nuageVMTableRow := ovsdb.NuageVMTableRow{
 		Type:            int(info.Type),
 		Event:           int(entity.EventCategoryDefined),
@@ -56,10 +68,12 @@ func (vrsConnection *VRSConnection) CreateEntity(info EntityInfo) error {
 		Ports:           info.Ports,
 	}
 
-	if err := vrsConnection.vmTable.InsertRow(vrsConnection.ovsdbClient, &nuageVMTableRow); err != nil {
+	if err := vrsConnection.vmTable.InsertRow(vrsConnection.client(), &nuageVMTableRow); err != nil {
 		return fmt.Errorf("Problem adding entity info to VRS %v", err)
 	}
 
+	vrsConnection.cacheEntity(info)
+
 	return nil
 }
 
@@ -67,10 +81,12 @@ func (vrsConnection *VRSConnection) CreateEntity(info EntityInfo) error {
 func (vrsConnection *VRSConnection) DestroyEntity(uuid string) error {
 
 	condition := []string{ovsdb.NuageVMTableColumnVMUUID, "==", uuid}
-	if err := vrsConnection.vmTable.DeleteRow(vrsConnection.ovsdbClient, condition); err != nil {
+	if err := vrsConnection.vmTable.DeleteRow(vrsConnection.client(), condition); err != nil {
 		return fmt.Errorf("Unable to delete the entity from VRS %v", err)
 	}
 
+	vrsConnection.uncacheEntity(uuid)
+
 	return nil
 }
 
@@ -91,10 +107,12 @@ func (vrsConnection *VRSConnection) AddEntityPort(uuid string, portName string)
 
 	condition := []string{ovsdb.NuageVMTableColumnVMUUID, "==", uuid}
 
-	if err = vrsConnection.vmTable.UpdateRow(vrsConnection.ovsdbClient, row, condition); err != nil {
+	if err = vrsConnection.vmTable.UpdateRow(vrsConnection.client(), row, condition); err != nil {
 		return fmt.Errorf("Unable to add port %s %s %s", uuid, portName, err)
 	}
 
+	vrsConnection.cacheEntityPorts(uuid, ports)
+
 	return nil
 }
 
@@ -128,10 +146,12 @@ func (vrsConnection *VRSConnection) RemoveEntityPort(uuid string, portName strin
 
 	condition := []string{ovsdb.NuageVMTableColumnVMUUID, "==", uuid}
 
-	if err = vrsConnection.vmTable.UpdateRow(vrsConnection.ovsdbClient, row, condition); err != nil {
+	if err = vrsConnection.vmTable.UpdateRow(vrsConnection.client(), row, condition); err != nil {
 		return fmt.Errorf("Unable to remove port %s %s %s", uuid, portName, err)
 	}
 
+	vrsConnection.cacheEntityPorts(uuid, ports)
+
 	return nil
 }
 
@@ -143,7 +163,7 @@ func (vrsConnection *VRSConnection) GetEntityPorts(uuid string) ([]string, error
 		Condition: []string{ovsdb.NuageVMTableColumnVMUUID, "==", uuid},
 	}
 
-	row, err := vrsConnection.vmTable.ReadRow(vrsConnection.ovsdbClient, readRowArgs)
+	row, err := vrsConnection.vmTable.ReadRow(vrsConnection.client(), readRowArgs)
 	if err != nil {
 		return []string{}, fmt.Errorf("Unable to get port information for the VM")
 	}
@@ -160,7 +180,7 @@ func (vrsConnection *VRSConnection) SetEntityState(uuid string, state entity.Sta
 
 	condition := []string{ovsdb.NuageVMTableColumnVMUUID, "==", uuid}
 
-	if err := vrsConnection.vmTable.UpdateRow(vrsConnection.ovsdbClient, row, condition); err != nil {
+	if err := vrsConnection.vmTable.UpdateRow(vrsConnection.client(), row, condition); err != nil {
 		return fmt.Errorf("Unable to update the state %s %v %v %v", uuid, state, subState, err)
 	}
 
@@ -180,7 +200,7 @@ func (vrsConnection *VRSConnection) PostEntityEvent(uuid string, evtCategory ent
 
 	condition := []string{ovsdb.NuageVMTableColumnVMUUID, "==", uuid}
 
-	if err := vrsConnection.vmTable.UpdateRow(vrsConnection.ovsdbClient, row, condition); err != nil {
+	if err := vrsConnection.vmTable.UpdateRow(vrsConnection.client(), row, condition); err != nil {
 		return fmt.Errorf("Unable to send the state %s %v %v %v", uuid, evtCategory, evt, err)
 	}
 
@@ -194,7 +214,7 @@ func (vrsConnection *VRSConnection) SetEntityMetadata(uuid string, metadata map[
 
 	condition := []string{ovsdb.NuageVMTableColumnVMUUID, "==", uuid}
 
-	if err := vrsConnection.vmTable.UpdateRow(vrsConnection.ovsdbClient, row, condition); err != nil {
+	if err := vrsConnection.vmTable.UpdateRow(vrsConnection.client(), row, condition); err != nil {
 		return fmt.Errorf("Unable to update the metadata %s %v %v", uuid, metadata, err)
 	}
 
@@ -210,7 +230,7 @@ func (vrsConnection *VRSConnection) GetAllEntities() ([]string, error) {
 
 	var uuidRows []map[string]interface{}
 	var err error
-	if uuidRows, err = vrsConnection.vmTable.ReadRows(vrsConnection.ovsdbClient, readRowArgs); err != nil {
+	if uuidRows, err = vrsConnection.vmTable.ReadRows(vrsConnection.client(), readRowArgs); err != nil {
 		return []string{}, fmt.Errorf("Unable to obtain the entity uuids %v", err)
 	}
 
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nuagenetworks/libvrsdk/api/entity"
+	"github.com/nuagenetworks/libvrsdk/api/metadatatag"
+	"github.com/nuagenetworks/libvrsdk/ovsdb"
+)
+
+// tagMetadataKeyPrefix namespaces symbolic MetadataTag keys within an
+// entity's Metadata map, so the VSD-style tag model can be layered on top
+// of the VM table without a schema change and without breaking consumers
+// that only understand plain Metadata key/value pairs.
+const tagMetadataKeyPrefix = "nuage.tag."
+
+// AttachMetadataTag applies tag to the entity identified by uuid, the same
+// way CreateEntity does for an entity's info.Tags at creation time. The
+// cached EntityInfo.Tags for uuid is updated to match, so a tag attached
+// after creation still survives resync replaying this entity after a
+// reconnect instead of being silently dropped.
+func (vrsConnection *VRSConnection) AttachMetadataTag(uuid string, tag metadatatag.MetadataTag) error {
+	metadata, err := vrsConnection.getEntityMetadata(uuid)
+	if err != nil {
+		return fmt.Errorf("Unable to read metadata for %s %v", uuid, err)
+	}
+
+	metadata[entity.MetadataKey(tagMetadataKeyPrefix+tag.Name)] = tag.ExternalID
+
+	if err := vrsConnection.SetEntityMetadata(uuid, metadata); err != nil {
+		return fmt.Errorf("Unable to attach tag %s to %s %v", tag.Name, uuid, err)
+	}
+
+	vrsConnection.cacheEntityTags(uuid, tagsFromMetadata(metadata))
+
+	return nil
+}
+
+// DetachMetadataTag removes the MetadataTag named tagName from the entity
+// identified by uuid. The cached EntityInfo.Tags for uuid is updated to
+// match; see AttachMetadataTag.
+func (vrsConnection *VRSConnection) DetachMetadataTag(uuid string, tagName string) error {
+	metadata, err := vrsConnection.getEntityMetadata(uuid)
+	if err != nil {
+		return fmt.Errorf("Unable to read metadata for %s %v", uuid, err)
+	}
+
+	delete(metadata, entity.MetadataKey(tagMetadataKeyPrefix+tagName))
+
+	if err := vrsConnection.SetEntityMetadata(uuid, metadata); err != nil {
+		return fmt.Errorf("Unable to detach tag %s from %s %v", tagName, uuid, err)
+	}
+
+	vrsConnection.cacheEntityTags(uuid, tagsFromMetadata(metadata))
+
+	return nil
+}
+
+// ListMetadataTags returns the MetadataTags currently attached to the
+// entity identified by uuid.
+func (vrsConnection *VRSConnection) ListMetadataTags(uuid string) ([]metadatatag.MetadataTag, error) {
+	metadata, err := vrsConnection.getEntityMetadata(uuid)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read metadata for %s %v", uuid, err)
+	}
+
+	return tagsFromMetadata(metadata), nil
+}
+
+// tagsFromMetadata extracts the MetadataTags folded into metadata under
+// tagMetadataKeyPrefix, trimming the prefix back off each key. It is the
+// inverse of the prefixing AttachMetadataTag/CreateEntity do when folding a
+// tag into an entity's Metadata map.
+func tagsFromMetadata(metadata map[entity.MetadataKey]string) []metadatatag.MetadataTag {
+	var tags []metadatatag.MetadataTag
+	for key, value := range metadata {
+		name := strings.TrimPrefix(string(key), tagMetadataKeyPrefix)
+		if name == string(key) {
+			continue
+		}
+		tags = append(tags, metadatatag.MetadataTag{Name: name, ExternalID: value})
+	}
+	return tags
+}
+
+// GetEntitiesByTag scans the VM table and returns the UUIDs of every
+// entity carrying the MetadataTag named name.
+func (vrsConnection *VRSConnection) GetEntitiesByTag(name string) ([]string, error) {
+	readRowArgs := ovsdb.ReadRowArgs{
+		Columns:   []string{ovsdb.NuageVMTableColumnVMUUID, ovsdb.NuageVMTableColumnMetadata},
+		Condition: []string{ovsdb.NuageVMTableColumnVMUUID, "!=", "xxxx"},
+	}
+
+	rows, err := vrsConnection.vmTable.ReadRows(vrsConnection.client(), readRowArgs)
+	if err != nil {
+		return []string{}, fmt.Errorf("Unable to scan entities for tag %s %v", name, err)
+	}
+
+	tagKey := tagMetadataKeyPrefix + name
+
+	var uuids []string
+	for _, row := range rows {
+		rawMetadata, err := ovsdb.UnMarshallOVSMap(row[ovsdb.NuageVMTableColumnMetadata])
+		if err != nil {
+			continue
+		}
+		if _, tagged := rawMetadata[tagKey]; tagged {
+			uuids = append(uuids, row[ovsdb.NuageVMTableColumnVMUUID].(string))
+		}
+	}
+
+	return uuids, nil
+}
+
+// getEntityMetadata reads the Metadata column for the entity identified by
+// uuid.
+func (vrsConnection *VRSConnection) getEntityMetadata(uuid string) (map[entity.MetadataKey]string, error) {
+	readRowArgs := ovsdb.ReadRowArgs{
+		Columns:   []string{ovsdb.NuageVMTableColumnMetadata},
+		Condition: []string{ovsdb.NuageVMTableColumnVMUUID, "==", uuid},
+	}
+
+	row, err := vrsConnection.vmTable.ReadRow(vrsConnection.client(), readRowArgs)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get metadata information for the VM")
+	}
+
+	rawMetadata, err := ovsdb.UnMarshallOVSMap(row[ovsdb.NuageVMTableColumnMetadata])
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := make(map[entity.MetadataKey]string)
+	for k, v := range rawMetadata {
+		metadata[entity.MetadataKey(k)] = v
+	}
+
+	return metadata, nil
+}
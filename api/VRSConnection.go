@@ -0,0 +1,248 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nuagenetworks/libvrsdk/api/metadatatag"
+	"github.com/nuagenetworks/libvrsdk/ovsdb"
+	"github.com/socketplane/libovsdb"
+)
+
+// reconnectRetryInterval is how long to wait between redial attempts when
+// the connection to the VRS is down.
+const reconnectRetryInterval = 2 * time.Second
+
+// VRSConnection represents a connection to the Nuage_Service OVSDB server
+// running on the VRS. It wraps the raw libovsdb client with automatic
+// reconnect so that callers do not have to restart the process whenever the
+// VRS bounces or the OVSDB connection drops.
+type VRSConnection struct {
+	target      string
+	ovsdbClient *libovsdb.OvsdbClient
+	vmTable     ovsdb.OvsdbTable
+
+	mutex         sync.Mutex
+	connected     bool
+	onReconnect   func()
+	knownEntities map[string]EntityInfo
+	watchers      []*entityWatcher
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	stopped  chan struct{}
+}
+
+// Connect dials the Nuage_Service OVSDB server at target. The returned
+// VRSConnection will automatically redial and re-establish its monitors if
+// the connection is lost; use OnReconnect to be notified when that happens.
+func Connect(target string) (*VRSConnection, error) {
+	vrsConnection := &VRSConnection{
+		target:        target,
+		vmTable:       ovsdb.NewNuageVMTable(),
+		knownEntities: make(map[string]EntityInfo),
+		stopCh:        make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+
+	if err := vrsConnection.dial(); err != nil {
+		return nil, err
+	}
+
+	go vrsConnection.reconnectLoop()
+
+	return vrsConnection, nil
+}
+
+// Disconnect tears down the connection to the VRS and stops reconnectLoop,
+// waiting for it to fully exit before returning. That wait matters: without
+// it, a reconnectLoop iteration that is already past its last stopCh check
+// (mid-resync, or about to invoke OnReconnect) could keep running and touch
+// the VRSConnection after Disconnect has returned, even though the caller
+// was told it was safe to treat the connection as torn down.
+func (vrsConnection *VRSConnection) Disconnect() {
+	vrsConnection.stopOnce.Do(func() {
+		close(vrsConnection.stopCh)
+	})
+
+	vrsConnection.mutex.Lock()
+	if vrsConnection.ovsdbClient != nil {
+		vrsConnection.ovsdbClient.Disconnect()
+	}
+	vrsConnection.connected = false
+	vrsConnection.mutex.Unlock()
+
+	<-vrsConnection.stopped
+}
+
+// client returns the current OVSDB client under lock. reconnectLoop
+// replaces this field whenever the connection is redialed, so every other
+// use of ovsdbClient must go through this accessor rather than reading the
+// field directly.
+func (vrsConnection *VRSConnection) client() *libovsdb.OvsdbClient {
+	vrsConnection.mutex.Lock()
+	defer vrsConnection.mutex.Unlock()
+	return vrsConnection.ovsdbClient
+}
+
+// dial establishes (or re-establishes) the underlying OVSDB connection.
+func (vrsConnection *VRSConnection) dial() error {
+	ovsdbClient, err := libovsdb.Connect(vrsConnection.target, nil)
+	if err != nil {
+		return fmt.Errorf("Unable to connect to VRS at %s %v", vrsConnection.target, err)
+	}
+
+	vrsConnection.mutex.Lock()
+	vrsConnection.ovsdbClient = ovsdbClient
+	vrsConnection.connected = true
+	vrsConnection.mutex.Unlock()
+
+	return nil
+}
+
+// reconnectLoop watches the underlying libovsdb connection and redials
+// Nuage_Service whenever it drops, re-establishing monitors and replaying
+// cached entity/port state before notifying the OnReconnect callback. It
+// checks stopCh while idle, before each redial attempt and before
+// resyncing, and returns at the first check after Disconnect closes it; a
+// retry sleep, or a resync and OnReconnect call already under way, runs to
+// completion first.
+func (vrsConnection *VRSConnection) reconnectLoop() {
+	defer close(vrsConnection.stopped)
+
+	for {
+		select {
+		case <-vrsConnection.stopCh:
+			return
+		case <-vrsConnection.client().Disconnected():
+		}
+
+		select {
+		case <-vrsConnection.stopCh:
+			return
+		default:
+		}
+
+		vrsConnection.mutex.Lock()
+		vrsConnection.connected = false
+		vrsConnection.mutex.Unlock()
+
+		for {
+			select {
+			case <-vrsConnection.stopCh:
+				return
+			default:
+			}
+			if err := vrsConnection.dial(); err == nil {
+				break
+			}
+			time.Sleep(reconnectRetryInterval)
+		}
+
+		select {
+		case <-vrsConnection.stopCh:
+			return
+		default:
+		}
+
+		vrsConnection.resync()
+		vrsConnection.resubscribeWatchers()
+
+		vrsConnection.mutex.Lock()
+		callback := vrsConnection.onReconnect
+		vrsConnection.mutex.Unlock()
+		if callback != nil {
+			callback()
+		}
+	}
+}
+
+// resync replays CreateEntity for every entity the library observed before
+// the connection dropped. The VRS may have forgotten entities that were
+// destroyed from the OVSDB side while we were disconnected, so this
+// re-establishes them rather than requiring the caller to notice and react
+// on its own. CreateEntity already writes info.Ports as part of the row, so
+// there is no separate per-port replay here; re-adding ports on top of a
+// row that was just created with them would double the port list.
+func (vrsConnection *VRSConnection) resync() {
+	vrsConnection.mutex.Lock()
+	entities := make([]EntityInfo, 0, len(vrsConnection.knownEntities))
+	for _, info := range vrsConnection.knownEntities {
+		entities = append(entities, info)
+	}
+	vrsConnection.mutex.Unlock()
+
+	for _, info := range entities {
+		vrsConnection.CreateEntity(info)
+	}
+}
+
+// OnReconnect registers a callback invoked after the VRSConnection has
+// redialed Nuage_Service and replayed its own cached entity state. Callers
+// such as CNI plugins should use this hook to re-push their view of
+// entities and ports, since the VRS may have lost anything created before
+// the restart that this library was not already caching.
+func (vrsConnection *VRSConnection) OnReconnect(callback func()) {
+	vrsConnection.mutex.Lock()
+	defer vrsConnection.mutex.Unlock()
+	vrsConnection.onReconnect = callback
+}
+
+// IsConnected reports whether the VRSConnection currently has a live OVSDB
+// session with the VRS.
+func (vrsConnection *VRSConnection) IsConnected() bool {
+	vrsConnection.mutex.Lock()
+	defer vrsConnection.mutex.Unlock()
+	return vrsConnection.connected
+}
+
+// Ping checks that the OVSDB connection to the VRS is alive by issuing a
+// lightweight echo request.
+func (vrsConnection *VRSConnection) Ping() error {
+	if !vrsConnection.IsConnected() {
+		return fmt.Errorf("Not connected to VRS")
+	}
+
+	if err := vrsConnection.client().Echo(); err != nil {
+		return fmt.Errorf("VRS did not respond to echo %v", err)
+	}
+
+	return nil
+}
+
+// cacheEntity remembers info so that it can be replayed after a reconnect.
+func (vrsConnection *VRSConnection) cacheEntity(info EntityInfo) {
+	vrsConnection.mutex.Lock()
+	defer vrsConnection.mutex.Unlock()
+	vrsConnection.knownEntities[info.UUID] = info
+}
+
+// uncacheEntity forgets an entity that was destroyed.
+func (vrsConnection *VRSConnection) uncacheEntity(uuid string) {
+	vrsConnection.mutex.Lock()
+	defer vrsConnection.mutex.Unlock()
+	delete(vrsConnection.knownEntities, uuid)
+}
+
+// cacheEntityPorts updates the cached port set for an entity.
+func (vrsConnection *VRSConnection) cacheEntityPorts(uuid string, ports []string) {
+	vrsConnection.mutex.Lock()
+	defer vrsConnection.mutex.Unlock()
+	if info, found := vrsConnection.knownEntities[uuid]; found {
+		info.Ports = ports
+		vrsConnection.knownEntities[uuid] = info
+	}
+}
+
+// cacheEntityTags updates the cached MetadataTag set for an entity, so that
+// tags attached or detached after creation are still present the next time
+// resync replays this entity's EntityInfo.
+func (vrsConnection *VRSConnection) cacheEntityTags(uuid string, tags []metadatatag.MetadataTag) {
+	vrsConnection.mutex.Lock()
+	defer vrsConnection.mutex.Unlock()
+	if info, found := vrsConnection.knownEntities[uuid]; found {
+		info.Tags = tags
+		vrsConnection.knownEntities[uuid] = info
+	}
+}
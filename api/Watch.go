@@ -0,0 +1,300 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/nuagenetworks/libvrsdk/api/entity"
+	"github.com/nuagenetworks/libvrsdk/ovsdb"
+	"github.com/socketplane/libovsdb"
+)
+
+// EntityEventType identifies the kind of change an EntityEvent represents.
+type EntityEventType int
+
+const (
+	// EntityAdded is emitted when a row appears in Nuage_VM_Table.
+	EntityAdded EntityEventType = iota
+	// EntityModified is emitted when a row in Nuage_VM_Table changes.
+	EntityModified
+	// EntityDeleted is emitted when a row disappears from Nuage_VM_Table.
+	EntityDeleted
+	// EntityOverflow is emitted, with UUID empty, when the subscriber fell
+	// behind and one or more events were dropped because its channel was
+	// full. Subscribers that see it should reconcile their view with
+	// GetAllEntities rather than assume they saw every change.
+	EntityOverflow
+)
+
+// EntityEvent describes a single change observed on Nuage_VM_Table.
+type EntityEvent struct {
+	Type   EntityEventType
+	UUID   string
+	Before EntityInfo
+	After  EntityInfo
+}
+
+// WatchFilter narrows down which entities a watcher is notified about.
+// All fields are matched client-side against each row update; Nuage_VM_Table
+// does not support monitor conditions expressive enough to push UUIDPrefix
+// or the Metadata match down to the server.
+type WatchFilter struct {
+	UUIDPrefix    string
+	Type          *entity.Type
+	MetadataKey   entity.MetadataKey
+	MetadataValue string
+}
+
+func (filter WatchFilter) matches(info EntityInfo) bool {
+	if filter.UUIDPrefix != "" && !strings.HasPrefix(info.UUID, filter.UUIDPrefix) {
+		return false
+	}
+
+	if filter.Type != nil && info.Type != *filter.Type {
+		return false
+	}
+
+	if filter.MetadataKey != "" {
+		value, found := info.Metadata[filter.MetadataKey]
+		if !found {
+			return false
+		}
+		if filter.MetadataValue != "" && value != filter.MetadataValue {
+			return false
+		}
+	}
+
+	return true
+}
+
+// entityWatcher is the OVSDB monitor notifier backing a single
+// WatchEntities subscription.
+type entityWatcher struct {
+	vrsConnection *VRSConnection
+	filters       []WatchFilter
+	events        chan EntityEvent
+
+	mutex sync.Mutex
+	rows  map[string]EntityInfo
+
+	// sendMutex guards events against a send racing with its close: Update
+	// holds mutex for its entire body (and calls emit from within that),
+	// while close still needs to rule out a send landing after the channel
+	// is gone. emit and close() both take sendMutex around the one thing
+	// they actually need to serialize, the channel operation itself, so
+	// close never waits for a whole Update to finish.
+	sendMutex sync.Mutex
+	closed    bool
+	dropped   int
+}
+
+func (watcher *entityWatcher) accepts(info EntityInfo) bool {
+	if len(watcher.filters) == 0 {
+		return true
+	}
+	for _, filter := range watcher.filters {
+		if filter.matches(info) {
+			return true
+		}
+	}
+	return false
+}
+
+// emit delivers event to the subscriber unless the watch has already been
+// cancelled. It takes sendMutex rather than mutex, which Update already
+// holds while calling emit, so this never blocks on that lock; sendMutex is
+// what keeps this from ever sending on a channel that close() is in the
+// middle of closing. If a prior emit had to drop an event because the
+// channel was full, this first tries to let the subscriber know via an
+// EntityOverflow event before delivering event itself, so a subscriber
+// that only reads occasionally still eventually learns it missed something
+// instead of silently drifting out of sync.
+func (watcher *entityWatcher) emit(event EntityEvent) {
+	watcher.sendMutex.Lock()
+	defer watcher.sendMutex.Unlock()
+
+	if watcher.closed {
+		return
+	}
+
+	if watcher.dropped > 0 {
+		select {
+		case watcher.events <- EntityEvent{Type: EntityOverflow}:
+			watcher.dropped = 0
+		default:
+		}
+	}
+
+	select {
+	case watcher.events <- event:
+	default:
+		watcher.dropped++
+	}
+}
+
+// close marks watcher as cancelled and closes events, unless another call
+// already did so. It takes sendMutex so that it can never race with a
+// concurrent emit: either emit observes closed still false and finishes its
+// send before close runs, or close runs first and every emit after it sees
+// closed and returns without touching events.
+func (watcher *entityWatcher) close() {
+	watcher.sendMutex.Lock()
+	defer watcher.sendMutex.Unlock()
+
+	if watcher.closed {
+		return
+	}
+	watcher.closed = true
+	close(watcher.events)
+}
+
+// Update implements libovsdb.Notifier, translating Nuage_VM_Table row
+// updates into EntityEvents.
+func (watcher *entityWatcher) Update(context interface{}, tableUpdates libovsdb.TableUpdates) {
+	tableUpdate, found := tableUpdates.Updates[ovsdb.NuageVMTableName]
+	if !found {
+		return
+	}
+
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+
+	for uuid, rowUpdate := range tableUpdate.Rows {
+		var before, after EntityInfo
+		haveBefore := rowUpdate.Old != nil
+		haveAfter := rowUpdate.New != nil
+
+		if haveBefore {
+			before = ovsdb.RowToEntityInfo(uuid, *rowUpdate.Old)
+		}
+		if haveAfter {
+			after = ovsdb.RowToEntityInfo(uuid, *rowUpdate.New)
+		}
+
+		switch {
+		case !haveBefore && haveAfter:
+			if !watcher.accepts(after) {
+				continue
+			}
+			watcher.rows[uuid] = after
+			watcher.emit(EntityEvent{Type: EntityAdded, UUID: uuid, After: after})
+		case haveBefore && haveAfter:
+			if !watcher.accepts(after) {
+				continue
+			}
+			watcher.rows[uuid] = after
+			watcher.emit(EntityEvent{Type: EntityModified, UUID: uuid, Before: before, After: after})
+		case haveBefore && !haveAfter:
+			cached, known := watcher.rows[uuid]
+			if !known {
+				cached = before
+			}
+			delete(watcher.rows, uuid)
+			if !watcher.accepts(cached) {
+				continue
+			}
+			watcher.emit(EntityEvent{Type: EntityDeleted, UUID: uuid, Before: cached})
+		}
+	}
+}
+
+// Locked implements libovsdb.Notifier; watches do not use OVSDB locks.
+func (watcher *entityWatcher) Locked([]interface{}) {}
+
+// Stolen implements libovsdb.Notifier; watches do not use OVSDB locks.
+func (watcher *entityWatcher) Stolen([]interface{}) {}
+
+// Echo implements libovsdb.Notifier.
+func (watcher *entityWatcher) Echo([]interface{}) {}
+
+// Disconnected implements libovsdb.Notifier; reconnects are handled by
+// VRSConnection.reconnectLoop, which re-establishes the monitor and emits
+// a synthetic resync event once the connection is back.
+func (watcher *entityWatcher) Disconnected(*libovsdb.OvsdbClient) {}
+
+func (watcher *entityWatcher) resyncEvent() EntityEvent {
+	return EntityEvent{Type: EntityModified, UUID: "", After: EntityInfo{}}
+}
+
+// WatchEntities streams EntityEvents for changes to Nuage_VM_Table, so CNI
+// daemons and observability agents can react to state/event/metadata
+// changes without polling GetAllEntities. The returned channel is closed
+// when ctx is cancelled. If filters are given, an entity must match at
+// least one of them to be reported. The watch survives reconnects: the
+// monitor is re-established automatically and a synthetic EntityModified
+// event with an empty UUID is emitted afterwards so subscribers know to
+// reconcile their view via GetAllEntities. The channel is buffered but
+// bounded; a subscriber that falls behind will see events dropped rather
+// than block the watch, and is told so via an EntityOverflow event so it
+// knows to reconcile via GetAllEntities instead of assuming it saw
+// everything.
+func (vrsConnection *VRSConnection) WatchEntities(ctx context.Context, filters ...WatchFilter) (<-chan EntityEvent, error) {
+	watcher := &entityWatcher{
+		vrsConnection: vrsConnection,
+		filters:       filters,
+		events:        make(chan EntityEvent, 64),
+		rows:          make(map[string]EntityInfo),
+	}
+
+	if err := vrsConnection.startMonitor(watcher); err != nil {
+		return nil, fmt.Errorf("Unable to start entity watch %v", err)
+	}
+
+	vrsConnection.mutex.Lock()
+	vrsConnection.watchers = append(vrsConnection.watchers, watcher)
+	vrsConnection.mutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		vrsConnection.removeWatcher(watcher)
+		vrsConnection.client().Unregister(watcher)
+		watcher.close()
+	}()
+
+	return watcher.events, nil
+}
+
+// startMonitor installs an OVSDB monitor on Nuage_VM_Table that drives
+// notifier's Update callback.
+func (vrsConnection *VRSConnection) startMonitor(notifier libovsdb.Notifier) error {
+	ovsdbClient := vrsConnection.client()
+
+	ovsdbClient.Register(notifier)
+
+	_, err := ovsdbClient.Monitor(nuageServiceDatabase, nil, ovsdb.NuageVMTableMonitorRequests())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (vrsConnection *VRSConnection) removeWatcher(watcher *entityWatcher) {
+	vrsConnection.mutex.Lock()
+	defer vrsConnection.mutex.Unlock()
+
+	for i, w := range vrsConnection.watchers {
+		if w == watcher {
+			vrsConnection.watchers = append(vrsConnection.watchers[:i], vrsConnection.watchers[i+1:]...)
+			break
+		}
+	}
+}
+
+// resubscribeWatchers re-establishes every live watch's OVSDB monitor after
+// a reconnect and emits a synthetic resync event so subscribers reconcile
+// their view instead of assuming they saw every change while disconnected.
+func (vrsConnection *VRSConnection) resubscribeWatchers() {
+	vrsConnection.mutex.Lock()
+	watchers := append([]*entityWatcher{}, vrsConnection.watchers...)
+	vrsConnection.mutex.Unlock()
+
+	for _, watcher := range watchers {
+		if err := vrsConnection.startMonitor(watcher); err != nil {
+			continue
+		}
+		watcher.emit(watcher.resyncEvent())
+	}
+}
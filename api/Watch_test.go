@@ -0,0 +1,139 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/nuagenetworks/libvrsdk/api/entity"
+)
+
+func TestWatchFilterMatches(t *testing.T) {
+	vmType := entity.Type(0)
+	containerType := entity.Type(1)
+
+	tests := []struct {
+		name   string
+		filter WatchFilter
+		info   EntityInfo
+		want   bool
+	}{
+		{
+			name:   "empty filter matches anything",
+			filter: WatchFilter{},
+			info:   EntityInfo{UUID: "vm-1"},
+			want:   true,
+		},
+		{
+			name:   "uuid prefix matches",
+			filter: WatchFilter{UUIDPrefix: "vm-"},
+			info:   EntityInfo{UUID: "vm-1"},
+			want:   true,
+		},
+		{
+			name:   "uuid prefix does not match",
+			filter: WatchFilter{UUIDPrefix: "container-"},
+			info:   EntityInfo{UUID: "vm-1"},
+			want:   false,
+		},
+		{
+			name:   "type matches",
+			filter: WatchFilter{Type: &vmType},
+			info:   EntityInfo{UUID: "vm-1", Type: vmType},
+			want:   true,
+		},
+		{
+			name:   "type does not match",
+			filter: WatchFilter{Type: &containerType},
+			info:   EntityInfo{UUID: "vm-1", Type: vmType},
+			want:   false,
+		},
+		{
+			name:   "metadata key present with no required value matches",
+			filter: WatchFilter{MetadataKey: "env"},
+			info:   EntityInfo{UUID: "vm-1", Metadata: map[entity.MetadataKey]string{"env": "prod"}},
+			want:   true,
+		},
+		{
+			name:   "metadata key missing does not match",
+			filter: WatchFilter{MetadataKey: "env"},
+			info:   EntityInfo{UUID: "vm-1", Metadata: map[entity.MetadataKey]string{"other": "x"}},
+			want:   false,
+		},
+		{
+			name:   "metadata key and value both match",
+			filter: WatchFilter{MetadataKey: "env", MetadataValue: "prod"},
+			info:   EntityInfo{UUID: "vm-1", Metadata: map[entity.MetadataKey]string{"env": "prod"}},
+			want:   true,
+		},
+		{
+			name:   "metadata key matches but value does not",
+			filter: WatchFilter{MetadataKey: "env", MetadataValue: "prod"},
+			info:   EntityInfo{UUID: "vm-1", Metadata: map[entity.MetadataKey]string{"env": "staging"}},
+			want:   false,
+		},
+		{
+			name:   "every field must match",
+			filter: WatchFilter{UUIDPrefix: "vm-", Type: &vmType, MetadataKey: "env", MetadataValue: "prod"},
+			info: EntityInfo{
+				UUID:     "vm-1",
+				Type:     vmType,
+				Metadata: map[entity.MetadataKey]string{"env": "prod"},
+			},
+			want: true,
+		},
+		{
+			name:   "one mismatching field fails the whole filter",
+			filter: WatchFilter{UUIDPrefix: "vm-", Type: &containerType, MetadataKey: "env", MetadataValue: "prod"},
+			info: EntityInfo{
+				UUID:     "vm-1",
+				Type:     vmType,
+				Metadata: map[entity.MetadataKey]string{"env": "prod"},
+			},
+			want: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.filter.matches(test.info); got != test.want {
+				t.Errorf("matches() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEntityWatcherAcceptsIsOrAcrossFilters(t *testing.T) {
+	vmType := entity.Type(0)
+	containerType := entity.Type(1)
+
+	watcher := &entityWatcher{
+		filters: []WatchFilter{
+			{UUIDPrefix: "vm-"},
+			{Type: &containerType},
+		},
+	}
+
+	tests := []struct {
+		name string
+		info EntityInfo
+		want bool
+	}{
+		{name: "matches first filter only", info: EntityInfo{UUID: "vm-1", Type: vmType}, want: true},
+		{name: "matches second filter only", info: EntityInfo{UUID: "other-1", Type: containerType}, want: true},
+		{name: "matches neither filter", info: EntityInfo{UUID: "other-1", Type: vmType}, want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := watcher.accepts(test.info); got != test.want {
+				t.Errorf("accepts() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestEntityWatcherAcceptsWithNoFiltersMatchesEverything(t *testing.T) {
+	watcher := &entityWatcher{}
+	if !watcher.accepts(EntityInfo{UUID: "anything"}) {
+		t.Errorf("accepts() with no filters should match every entity")
+	}
+}
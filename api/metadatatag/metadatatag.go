@@ -0,0 +1,20 @@
+// Package metadatatag models Nuage MetadataTags on the VRS side, mirroring
+// the tag semantics the VSD exposes so that a CNI plugin can group
+// entities without a REST round-trip to the VSD.
+package metadatatag
+
+// MetadataTag represents a symbolic tag that can be attached to one or more
+// entities on the VRS. Unlike the VSD's MetadataTag, the VRS has nowhere to
+// persist more than a name and a single associated value per entity, so
+// MetadataTag only models the fields that can actually round-trip through
+// the VM table's Metadata column: Description, AssociatedExternalServiceID
+// and AutoCreated are VSD-only concepts and are not represented here.
+type MetadataTag struct {
+	Name       string
+	ExternalID string
+}
+
+// New returns a new MetadataTag with the given name.
+func New(name string) MetadataTag {
+	return MetadataTag{Name: name}
+}